@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Track is one recorded request/response pair, the unit of storage a Cassette persists.
+type Track struct {
+	Request    HttpRequestSerializable
+	Response   HttpResponseSerializable
+	RecordedAt time.Time
+}
+
+// Cassette persists tracks to some on-disk format. Implementations are not expected to be safe
+// for concurrent use; callers serialize access (see VcrRecorder's mutex).
+type Cassette interface {
+	// Append persists one more track, in order. Implementations may do this however best suits
+	// their format (e.g. a full read-modify-write of the file), not necessarily without touching
+	// tracks already on disk.
+	Append(track Track) error
+	// Load reads every track currently on disk, in recorded order.
+	Load() ([]Track, error)
+	// Close flushes and releases any resources (e.g. the underlying file handle).
+	Close() error
+}
+
+// NewCassette picks a Cassette implementation for filepath based on its extension:
+// ".json" -> JSON, ".har" -> HAR 1.2, anything else (including ".yaml"/".yml") -> YAML.
+func NewCassette(filepath string) (Cassette, error) {
+	switch strings.ToLower(extensionOf(filepath)) {
+	case ".json":
+		return newJSONCassette(filepath)
+	case ".har":
+		return newHARCassette(filepath)
+	default:
+		return newYAMLCassette(filepath)
+	}
+}
+
+func extensionOf(filepath string) string {
+	dot := strings.LastIndex(filepath, ".")
+	if dot == -1 {
+		return ""
+	}
+	return filepath[dot:]
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// harCassette stores tracks as a HAR 1.2 (HTTP Archive) log, the format browser devtools and
+// other HTTP-replay tooling speak, so cassettes recorded here can be opened directly in Chrome
+// DevTools' Network panel.
+type harCassette struct {
+	filepath string
+}
+
+func newHARCassette(filepath string) (*harCassette, error) {
+	return &harCassette{filepath: filepath}, nil
+}
+
+func (c *harCassette) Append(track Track) error {
+	log, err := c.readLog()
+	if err != nil {
+		return err
+	}
+
+	log.Entries = append(log.Entries, trackToHAREntry(track))
+
+	return c.writeLog(log)
+}
+
+func (c *harCassette) Load() ([]Track, error) {
+	log, err := c.readLog()
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]Track, len(log.Entries))
+	for i, entry := range log.Entries {
+		tracks[i] = harEntryToTrack(entry)
+	}
+	return tracks, nil
+}
+
+func (c *harCassette) Close() error {
+	return nil
+}
+
+func (c *harCassette) readLog() (harLog, error) {
+	bytes, err := os.ReadFile(c.filepath)
+	if os.IsNotExist(err) {
+		return newHARLog(), nil
+	} else if err != nil {
+		return harLog{}, err
+	}
+
+	var file harFile
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return harLog{}, err
+	}
+	return file.Log, nil
+}
+
+func (c *harCassette) writeLog(log harLog) error {
+	bytes, err := json.MarshalIndent(harFile{Log: log}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.filepath, bytes, 0644)
+}
+
+// --- HAR 1.2 document shape (https://w3c.github.io/web-performance/specs/HAR/Overview.html) ---
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Pages   []harPage   `json:"pages"`
+	Entries []harEntry  `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPage struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	ID              string    `json:"id"`
+	Title           string    `json:"title"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time    `json:"startedDateTime"`
+	Time            float64      `json:"time"`
+	Request         harRequest   `json:"request"`
+	Response        harResponse  `json:"response"`
+	Cache           harCache     `json:"cache"`
+	Timings         harTimings   `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCache struct{}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHARLog() harLog {
+	return harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "stripe-cli HttpVcr", Version: "1.0"},
+		Pages:   []harPage{},
+		Entries: []harEntry{},
+	}
+}
+
+func headerToHARNameValues(header http.Header) []harNameValue {
+	values := make([]harNameValue, 0, len(header))
+	for name, vs := range header {
+		for _, v := range vs {
+			values = append(values, harNameValue{Name: name, Value: v})
+		}
+	}
+	return values
+}
+
+func harNameValuesToHeader(values []harNameValue) http.Header {
+	header := make(http.Header, len(values))
+	for _, nv := range values {
+		header.Add(nv.Name, nv.Value)
+	}
+	return header
+}
+
+func bodyToHARText(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harTextToBody(text, encoding string) []byte {
+	if encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(text)
+		if err == nil {
+			return decoded
+		}
+	}
+	return []byte(text)
+}
+
+func trackToHAREntry(track Track) harEntry {
+	req := track.Request
+	resp := track.Response
+
+	var postData *harPostData
+	if len(req.Body) > 0 {
+		requestBodyText, requestEncoding := bodyToHARText(req.Body)
+		postData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: requestBodyText, Encoding: requestEncoding}
+	}
+
+	responseBodyText, responseEncoding := bodyToHARText(resp.Body)
+
+	query := make([]harNameValue, 0)
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, harNameValue{Name: name, Value: value})
+		}
+	}
+
+	return harEntry{
+		StartedDateTime: track.RecordedAt,
+		Time:            0,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     headerToHARNameValues(req.Header),
+			QueryString: query,
+			PostData:    postData,
+			HeadersSize: -1,
+			BodySize:    len(req.Body),
+		},
+		Response: harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     headerToHARNameValues(resp.Header),
+			Content: harContent{
+				Size:     len(resp.Body),
+				MimeType: resp.Header.Get("Content-Type"),
+				Text:     responseBodyText,
+				Encoding: responseEncoding,
+			},
+			HeadersSize: -1,
+			BodySize:    len(resp.Body),
+		},
+		Cache:   harCache{},
+		Timings: harTimings{Send: 0, Wait: 0, Receive: 0},
+	}
+}
+
+func harEntryToTrack(entry harEntry) Track {
+	parsedURL, _ := url.Parse(entry.Request.URL)
+	if parsedURL == nil {
+		parsedURL = &url.URL{}
+	}
+
+	var requestBody []byte
+	if entry.Request.PostData != nil {
+		requestBody = harTextToBody(entry.Request.PostData.Text, entry.Request.PostData.Encoding)
+	}
+
+	return Track{
+		Request: HttpRequestSerializable{
+			Method: entry.Request.Method,
+			URL:    *parsedURL,
+			Header: harNameValuesToHeader(entry.Request.Headers),
+			Body:   requestBody,
+		},
+		Response: HttpResponseSerializable{
+			StatusCode: entry.Response.Status,
+			Header:     harNameValuesToHeader(entry.Response.Headers),
+			Body:       harTextToBody(entry.Response.Content.Text, entry.Response.Content.Encoding),
+		},
+		RecordedAt: entry.StartedDateTime,
+	}
+}
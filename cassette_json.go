@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// jsonCassetteFile is the on-disk shape of a JSON cassette: a simple ordered list of tracks.
+type jsonCassetteFile struct {
+	Tracks []Track `json:"tracks"`
+}
+
+// jsonCassette stores tracks as a single indented JSON document.
+type jsonCassette struct {
+	filepath string
+}
+
+func newJSONCassette(filepath string) (*jsonCassette, error) {
+	return &jsonCassette{filepath: filepath}, nil
+}
+
+func (c *jsonCassette) Append(track Track) error {
+	file, err := c.readFile()
+	if err != nil {
+		return err
+	}
+
+	file.Tracks = append(file.Tracks, track)
+
+	return c.writeFile(file)
+}
+
+func (c *jsonCassette) Load() ([]Track, error) {
+	file, err := c.readFile()
+	if err != nil {
+		return nil, err
+	}
+	return file.Tracks, nil
+}
+
+func (c *jsonCassette) Close() error {
+	return nil
+}
+
+func (c *jsonCassette) readFile() (jsonCassetteFile, error) {
+	var file jsonCassetteFile
+
+	bytes, err := os.ReadFile(c.filepath)
+	if os.IsNotExist(err) {
+		return file, nil
+	} else if err != nil {
+		return file, err
+	}
+
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return file, err
+	}
+	return file, nil
+}
+
+func (c *jsonCassette) writeFile(file jsonCassetteFile) error {
+	bytes, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.filepath, bytes, 0644)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// TestRecordAndReloadRoundTripsRequestBody records one track with a non-empty request body,
+// reloads the cassette from disk, and asserts the request and response bodies come back
+// unchanged, for each of the YAML, JSON, and HAR backends. It calls the recorder directly, so
+// HttpVcr.handler's request-body snapshot is not exercised here.
+func TestRecordAndReloadRoundTripsRequestBody(t *testing.T) {
+	for _, ext := range []string{".yaml", ".json", ".har"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cassette"+ext)
+
+			cassette, err := NewCassette(path)
+			if err != nil {
+				t.Fatalf("NewCassette: %v", err)
+			}
+
+			recorder := NewRecorderWithCassette(cassette)
+			req := HttpRequestSerializable{
+				Method: http.MethodPost,
+				URL:    url.URL{Path: "/v1/charges"},
+				Header: http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}},
+				Body:   []byte("amount=100&currency=usd"),
+			}
+			resp := HttpResponseSerializable{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       []byte(`{"id":"ch_123"}`),
+			}
+
+			if err := recorder.Write(req, resp); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := recorder.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			reloaded, err := NewCassette(path)
+			if err != nil {
+				t.Fatalf("NewCassette (reload): %v", err)
+			}
+
+			tracks, err := reloaded.Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if len(tracks) != 1 {
+				t.Fatalf("got %d tracks, want 1", len(tracks))
+			}
+
+			if got := string(tracks[0].Request.Body); got != string(req.Body) {
+				t.Errorf("request body = %q, want %q", got, req.Body)
+			}
+			if got := string(tracks[0].Response.Body); got != string(resp.Body) {
+				t.Errorf("response body = %q, want %q", got, resp.Body)
+			}
+		})
+	}
+}
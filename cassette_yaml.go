@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlCassetteFile is the on-disk shape of a YAML cassette: a simple ordered list of tracks.
+type yamlCassetteFile struct {
+	Tracks []Track `yaml:"tracks"`
+}
+
+// yamlCassette is the original cassette format: one YAML document per file, tracks in record order.
+type yamlCassette struct {
+	filepath string
+}
+
+func newYAMLCassette(filepath string) (*yamlCassette, error) {
+	return &yamlCassette{filepath: filepath}, nil
+}
+
+func (c *yamlCassette) Append(track Track) error {
+	file, err := c.readFile()
+	if err != nil {
+		return err
+	}
+
+	file.Tracks = append(file.Tracks, track)
+
+	return c.writeFile(file)
+}
+
+func (c *yamlCassette) Load() ([]Track, error) {
+	file, err := c.readFile()
+	if err != nil {
+		return nil, err
+	}
+	return file.Tracks, nil
+}
+
+func (c *yamlCassette) Close() error {
+	return nil
+}
+
+func (c *yamlCassette) readFile() (yamlCassetteFile, error) {
+	var file yamlCassetteFile
+
+	bytes, err := os.ReadFile(c.filepath)
+	if os.IsNotExist(err) {
+		return file, nil
+	} else if err != nil {
+		return file, err
+	}
+
+	if err := yaml.Unmarshal(bytes, &file); err != nil {
+		return file, err
+	}
+	return file, nil
+}
+
+func (c *yamlCassette) writeFile(file yamlCassetteFile) error {
+	bytes, err := yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.filepath, bytes, 0644)
+}
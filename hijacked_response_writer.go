@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// hijackedResponseWriter implements http.ResponseWriter over a raw, already-hijacked connection
+// (used for MITM'd HTTPS requests, where there is no surrounding http.Server to write the
+// response for us). The body is buffered in memory and the status line, headers, and body are
+// only written to the wire by flush, once the handler has finished writing the body. This lets
+// flush compute a Content-Length for upstream responses that arrive without one (Go's
+// http.Client strips both Content-Length and Transfer-Encoding from chunked responses), which a
+// surrounding net/http.Server would otherwise supply automatically.
+type hijackedResponseWriter struct {
+	conn        net.Conn
+	writer      *bufio.Writer
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newHijackedResponseWriter(conn net.Conn) *hijackedResponseWriter {
+	return &hijackedResponseWriter{
+		conn:   conn,
+		writer: bufio.NewWriter(conn),
+		header: make(http.Header),
+	}
+}
+
+func (rw *hijackedResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *hijackedResponseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.statusCode = statusCode
+}
+
+func (rw *hijackedResponseWriter) Write(data []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.body.Write(data)
+}
+
+// flush sends the status line, headers, and buffered body to the client. If the handler didn't
+// set Content-Length or Transfer-Encoding itself, flush fills in Content-Length from the
+// buffered body so the client has proper length framing to know where the response ends.
+func (rw *hijackedResponseWriter) flush() error {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.header.Get("Content-Length") == "" && rw.header.Get("Transfer-Encoding") == "" {
+		rw.header.Set("Content-Length", strconv.Itoa(rw.body.Len()))
+	}
+
+	fmt.Fprintf(rw.writer, "HTTP/1.1 %d %s\r\n", rw.statusCode, http.StatusText(rw.statusCode))
+	rw.header.Write(rw.writer)
+	rw.writer.WriteString("\r\n")
+	rw.writer.Write(rw.body.Bytes())
+
+	return rw.writer.Flush()
+}
@@ -2,55 +2,172 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"os/exec"
+	"net/url"
+	"sync"
 )
 
+// hopByHopHeaders lists headers that describe a single transport-level connection and must not be
+// forwarded across a proxy hop, per RFC 7230 section 6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RequestMutator edits a request in place before it is persisted to the cassette (record time)
+// or before it is matched against a track (replay time).
+type RequestMutator func(req *HttpRequestSerializable) error
+
+// ResponseMutator edits a response in place before it is persisted to the cassette (record time)
+// or before it is handed back to the client (replay time). The request is provided read-only
+// context, e.g. to key a rewrite off the path that was hit.
+type ResponseMutator func(req *HttpRequestSerializable, resp *HttpResponseSerializable) error
+
 type HttpVcr struct {
-	recorder   *VcrRecorder
-	replayer   *VcrReplayer
-	recordMode bool
-	remoteURL  string // base URL of remote without trailing `/`
+	// mu guards every field below that the /vcr control API can change at runtime
+	// (mode, cassetteName, recorder, replayer), since those can be swapped out mid-flight.
+	mu           sync.Mutex
+	mode         VcrMode
+	cassetteName string
+	recorder     *VcrRecorder
+	replayer     *VcrReplayer
+
+	remoteURL string // base URL of remote without trailing `/`
+
+	requestMutators  []RequestMutator
+	responseMutators []ResponseMutator
+
+	requestMatcher RequestMatcher
+	strictOrder    bool
+	matchMany      bool
+
+	ca *CertificateAuthority
+
+	cassette Cassette // overrides extension-based backend selection when set
 }
 
-func NewHttpVcr(filepath string, recordMode bool, remoteURL string) (vcr HttpVcr, err error) {
-	vcr = HttpVcr{}
+// HttpVcrOption configures optional behavior on NewHttpVcr, e.g. WithRequestMutator.
+type HttpVcrOption func(*HttpVcr)
 
-	if recordMode {
-		// delete file if exists
-		if _, err := os.Stat(filepath); !os.IsNotExist(err) {
-			err = os.Remove(filepath)
-			if err != nil {
-				return vcr, err
-			}
-		}
+// WithRequestMutator registers a RequestMutator that runs, in registration order, on every
+// request at record time (before it is written to the cassette) and at replay time (before the
+// request is used to look up a track). Typical uses: redacting secrets, normalizing IDs.
+func WithRequestMutator(mutator RequestMutator) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.requestMutators = append(vcr.requestMutators, mutator)
+	}
+}
 
-		recorder, e := NewRecorder(filepath)
-		vcr.recorder = recorder
-		err = e
-	} else {
-		// delete file if exists
-		if _, err := os.Stat(filepath); os.IsNotExist(err) {
-			return vcr, err
-		}
+// WithResponseMutator registers a ResponseMutator that runs, in registration order, on every
+// response at record time (before it is written to the cassette) and at replay time (after a
+// track is loaded, before the response is returned to the client).
+func WithResponseMutator(mutator ResponseMutator) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.responseMutators = append(vcr.responseMutators, mutator)
+	}
+}
+
+// WithRequestMatcher overrides the default replay matcher (MatchMethodAndPath) with matcher. Compose built-in matchers with AndMatchers/OrMatchers for multi-field matching.
+func WithRequestMatcher(matcher RequestMatcher) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.requestMatcher = matcher
+	}
+}
+
+// WithStrictOrder requires tracks to be consumed in the order they were recorded: a track is
+// only offered to the matcher once every earlier track has been played. Off by default, so the
+// matcher is free to pick the best match anywhere in the remaining cassette.
+func WithStrictOrder(strict bool) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.strictOrder = strict
+	}
+}
+
+// WithMatchMany switches every track from the default match-once semantics (a track is marked
+// played and never offered again once it answers a request) to match-many: a matched track stays
+// available and can answer any number of later requests, e.g. for a health-check or auth-refresh
+// call that repeats throughout a test run.
+func WithMatchMany(matchMany bool) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.matchMany = matchMany
+	}
+}
+
+// WithCertificateAuthority enables MITM interception of CONNECT requests to arbitrary upstream
+// hosts, using ca to mint a leaf certificate per SNI hostname on the fly. Without this option,
+// CONNECT requests are rejected.
+func WithCertificateAuthority(ca *CertificateAuthority) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.ca = ca
+	}
+}
+
+// WithCassette overrides the default extension-based backend selection (YAML/JSON/HAR by file
+// extension) with an explicit Cassette, e.g. to force HAR output regardless of filename.
+func WithCassette(cassette Cassette) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.cassette = cassette
+	}
+}
 
-		sequentialComparator := func(req1 interface{}, req2 interface{}) (accept bool, shortCircuitNow bool) {
-			return true, true
+// WithMode overrides the record/replay mode implied by NewHttpVcr's recordMode bool, e.g. to
+// start directly in ModePassthrough, ModeAuto, or ModeHybrid.
+func WithMode(mode VcrMode) HttpVcrOption {
+	return func(vcr *HttpVcr) {
+		vcr.mode = mode
+	}
+}
+
+// applyRequestMutators runs all registered request mutators in order, stopping at the first error.
+func (httpVcr *HttpVcr) applyRequestMutators(req *HttpRequestSerializable) error {
+	for _, mutate := range httpVcr.requestMutators {
+		if err := mutate(req); err != nil {
+			return fmt.Errorf("request mutator failed: %w", err)
 		}
+	}
+	return nil
+}
 
-		replayer, e := NewReplayer(filepath, HttpRequestSerializable{}, HttpResponseSerializable{}, sequentialComparator)
-		vcr.replayer = replayer
-		err = e
+// applyResponseMutators runs all registered response mutators in order, stopping at the first error.
+func (httpVcr *HttpVcr) applyResponseMutators(req *HttpRequestSerializable, resp *HttpResponseSerializable) error {
+	for _, mutate := range httpVcr.responseMutators {
+		if err := mutate(req, resp); err != nil {
+			return fmt.Errorf("response mutator failed: %w", err)
+		}
 	}
+	return nil
+}
 
-	vcr.recordMode = recordMode
+func NewHttpVcr(filepath string, recordMode bool, remoteURL string, opts ...HttpVcrOption) (vcr *HttpVcr, err error) {
+	vcr = &HttpVcr{}
 	vcr.remoteURL = remoteURL
-	return vcr, err
+	vcr.cassetteName = filepath
+	if recordMode {
+		vcr.mode = ModeRecord
+	} else {
+		vcr.mode = ModeReplay
+	}
+
+	for _, opt := range opts {
+		opt(vcr)
+	}
+
+	if err := vcr.openCassetteLocked(vcr.cassetteName, vcr.mode); err != nil {
+		return vcr, err
+	}
+
+	return vcr, nil
 }
 
 func handleErrorInHandler(w http.ResponseWriter, err error) {
@@ -66,51 +183,107 @@ func handleErrorInHandler(w http.ResponseWriter, err error) {
 func (httpVcr *HttpVcr) handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("\n--> %v to %v", r.Method, r.RequestURI)
 
-	// --- pass to VCR, get response back
-	var resp *http.Response
-	var err error
-	if httpVcr.recordMode {
-		resp, err = httpVcr.getResponseFromRemote(r)
-		if err != nil {
-			handleErrorInHandler(w, err)
-			return
-		}
-		fmt.Printf("\n<-- %v from %v\n", resp.Status, "REMOTE")
-	} else {
-		resp, err = httpVcr.getNextRecordedCassetteResponse(r)
-		if err != nil {
-			handleErrorInHandler(w, err)
+	if r.Method == http.MethodConnect {
+		if httpVcr.ca == nil {
+			http.Error(w, "MITM is not configured on this HttpVcr (no CertificateAuthority)", http.StatusNotImplemented)
 			return
 		}
-		fmt.Printf("\n<-- %v from %v\n", resp.Status, "CASSETTE")
+		httpVcr.ca.handleConnect(w, r, httpVcr.handler)
+		return
 	}
+
+	// Snapshot the request body before dispatch gets anywhere near it: getResponseFromRemote
+	// streams r.Body straight into the live upstream call and drains it, so if we waited until
+	// after dispatch to build the record-time snapshot (as before), every recorded request with
+	// a body would be persisted as body: []. NewSerializableHttpRequest refills r.Body from the
+	// bytes it just read, so dispatch still sees the full body to forward upstream or match
+	// against the cassette.
+	serializableRequest := NewSerializableHttpRequest(r)
+
+	// --- pass to VCR, get response back
+	resp, source, shouldRecord, err := httpVcr.dispatch(r)
+	if err != nil {
+		handleErrorInHandler(w, err)
+		return
+	}
+	fmt.Printf("\n<-- %v from %v\n", resp.Status, source)
 	defer resp.Body.Close() // we need to close the body
 
-	// take response and write the httpResponse
-	// TODO: this is kind of a piecemeal way to transfer data from the proxied response
-	// 		 Is there a way to copy and return the entire proxied response? (and not worry about missing a field)
+	// take response and stream it back to the client as-is, headers included
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
 	w.WriteHeader(resp.StatusCode)
-	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	w.Header().Set("Content-Length", resp.Header.Get("Content-Length"))
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+
+	// tee the body to the client and a buffer at the same time, so the recorder sees exactly what
+	// the client saw without buffering the whole response in memory first (fixes the previous
+	// ordering bug where the client write and the recorder write raced over the same bytes)
+	var recorded bytes.Buffer
+	dest := io.Writer(w)
+	if shouldRecord {
+		dest = io.MultiWriter(w, &recorded)
+	}
+	if _, err = io.Copy(dest, resp.Body); err != nil {
 		handleErrorInHandler(w, err)
 		return
 	}
 
-	io.Copy(w, bytes.NewBuffer(bodyBytes)) // TODO: there is an ordering bug between this and recorder.Write() below
+	if shouldRecord {
+		resp.Body = ioutil.NopCloser(&recorded)
 
-	resp.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+		serializableResponse := NewSerializableHttpResponse(resp)
+
+		if err = httpVcr.applyRequestMutators(&serializableRequest); err != nil {
+			handleErrorInHandler(w, err)
+			return
+		}
+		if err = httpVcr.applyResponseMutators(&serializableRequest, &serializableResponse); err != nil {
+			handleErrorInHandler(w, err)
+			return
+		}
 
-	if httpVcr.recordMode {
-		err = httpVcr.recorder.Write(NewSerializableHttpRequest(r), NewSerializableHttpResponse(resp))
-		if err != nil {
+		if err = httpVcr.record(serializableRequest, serializableResponse); err != nil {
 			handleErrorInHandler(w, err)
 			return
 		}
 	}
 }
 
+// dispatch routes r according to the VCR's current mode and reports where the response came from
+// ("REMOTE" or "CASSETTE") and whether the exchange still needs to be recorded.
+func (httpVcr *HttpVcr) dispatch(r *http.Request) (resp *http.Response, source string, shouldRecord bool, err error) {
+	switch httpVcr.currentMode() {
+	case ModeRecord:
+		resp, err = httpVcr.getResponseFromRemote(r)
+		return resp, "REMOTE", err == nil, err
+
+	case ModePassthrough:
+		resp, err = httpVcr.getResponseFromRemote(r)
+		return resp, "REMOTE", false, err
+
+	case ModeReplay:
+		resp, err = httpVcr.getNextRecordedCassetteResponse(r)
+		return resp, "CASSETTE", false, err
+
+	case ModeHybrid, ModeAuto:
+		resp, err = httpVcr.getNextRecordedCassetteResponse(r)
+		if err == nil {
+			return resp, "CASSETTE", false, nil
+		}
+		if _, noMatch := err.(*NearMissError); !noMatch {
+			return nil, "CASSETTE", false, err
+		}
+
+		resp, err = httpVcr.getResponseFromRemote(r)
+		return resp, "REMOTE", err == nil, err
+
+	default:
+		return nil, "", false, fmt.Errorf("unknown VCR mode %q", httpVcr.currentMode())
+	}
+}
+
 func (httpVcr *HttpVcr) InitializeServer(address string) *http.Server {
 	customMux := http.NewServeMux()
 	server := &http.Server{Addr: address, Handler: customMux}
@@ -121,39 +294,30 @@ func (httpVcr *HttpVcr) InitializeServer(address string) *http.Server {
 		fmt.Println()
 		fmt.Println("Received /vcr/stop. Stopping...")
 
-		httpVcr.recorder.Close()
+		if recorder := httpVcr.currentRecorder(); recorder != nil {
+			recorder.Close()
+		}
+	})
+
+	// --- VCR CA endpoint, so test harnesses can install our root CA into their trust store
+	customMux.HandleFunc("/vcr/ca.pem", func(w http.ResponseWriter, r *http.Request) {
+		if httpVcr.ca == nil {
+			http.Error(w, "MITM is not configured on this HttpVcr (no CertificateAuthority)", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(httpVcr.ca.certPEM())
 	})
 
+	// --- VCR cassette lifecycle / mode control API
+	httpVcr.registerControlAPI(customMux)
+
 	// --- Default VCR catch-all handler
 	customMux.HandleFunc("/", httpVcr.handler)
 
 	return server
 }
 
-// TODO: currently has issues - do manually for now
-func generateSelfSignedCertificates() error {
-	gorootPath := os.Getenv("GOROOT")
-	fmt.Println("GOROOT: ", gorootPath)
-	certGenerationScript := gorootPath + "/src/crypto/tls/generate_cert.go"
-	rsaBits := "2048"
-	host := "localhost, 127.0.0.1"
-	startDate := "Jan 1 00:00:00 1970"
-	duration := "--duration=100000h"
-
-	cmd := exec.Command("go", "run", certGenerationScript, "--rsa-bits", rsaBits, "--host", host, "--ca", "--start-date", startDate, duration)
-	// cmd := exec.Command("go env")
-	// cmd := exec.Command("ls")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("generating certs failed: %w", err)
-	} else {
-		return nil
-	}
-}
-
 func main() {
 	filepath := "main_result.yaml"
 	addressString := "localhost:8080"
@@ -161,7 +325,10 @@ func main() {
 	remoteURL := "https://api.stripe.com"
 	// remoteURL := "https://gobyexample.com"
 
-	httpVcr, err := NewHttpVcr(filepath, recordMode, remoteURL)
+	ca, err := NewCertificateAuthority("vcr-ca-cert.pem", "vcr-ca-key.pem")
+	check(err)
+
+	httpVcr, err := NewHttpVcr(filepath, recordMode, remoteURL, WithCertificateAuthority(ca))
 	check(err)
 
 	fmt.Println()
@@ -170,21 +337,61 @@ func main() {
 	fmt.Println()
 
 	server := httpVcr.InitializeServer(addressString)
+	server.TLSConfig = &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				host = "localhost"
+			}
+			return ca.leafFor(host)
+		},
+	}
 
-	log.Fatal(server.ListenAndServeTLS("cert.pem", "key.pem"))
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
 
+// getResponseFromRemote forwards request to the remote upstream as a transparent reverse proxy:
+// method, path, raw query, body, and all non-hop-by-hop headers are preserved, and Host is
+// rewritten to the remote's so name-based virtual hosting on the upstream works correctly.
 func (httpVcr *HttpVcr) getResponseFromRemote(request *http.Request) (resp *http.Response, err error) {
-	// TODO: placeholder proxy a request to some random website. Later - this should pass on the request
-	// We need to pass on the entire request (or at least the Authorization part of the header)
+	// MITM'd CONNECT traffic (see handleConnect) carries its real upstream in request.URL; every
+	// other request goes to remoteURL.
+	remoteURL := httpVcr.remoteURL
+	if request.URL.Host != "" {
+		scheme := request.URL.Scheme
+		if scheme == "" {
+			scheme = "https"
+		}
+		remoteURL = scheme + "://" + request.URL.Host
+	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest(request.Method, httpVcr.remoteURL+request.RequestURI, nil)
-	req.Header.Add("Authorization", request.Header.Get("Authorization"))
+	remote, err := url.Parse(remoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing remote URL %q: %w", remoteURL, err)
+	}
 
-	res, err := client.Do(req)
-	// res, err := http.Get(remoteUrl + request.URL.RequestURI())
+	targetURL := remoteURL + request.URL.Path
+	if request.URL.RawQuery != "" {
+		targetURL += "?" + request.URL.RawQuery
+	}
+
+	// request.Body is handed straight to the proxied request. By the time handler calls this, it
+	// has already read the whole body into memory for the cassette snapshot, so the body arrives
+	// here as an in-memory buffer.
+	proxyReq, err := http.NewRequest(request.Method, targetURL, request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyReq.Header = request.Header.Clone()
+	for _, header := range hopByHopHeaders {
+		proxyReq.Header.Del(header)
+	}
+	proxyReq.ContentLength = request.ContentLength
+	proxyReq.Host = remote.Host
 
+	client := &http.Client{}
+	res, err := client.Do(proxyReq)
 	if err != nil {
 		return nil, err
 	}
@@ -199,12 +406,28 @@ func (httpVcr *HttpVcr) getResponseFromRemote(request *http.Request) (resp *http
 func (httpVcr *HttpVcr) getNextRecordedCassetteResponse(request *http.Request) (resp *http.Response, err error) {
 	// the passed in request arg may not be necessary
 
-	responseWrapper, err := httpVcr.replayer.Write(NewSerializableHttpRequest(request))
+	serializableRequest := NewSerializableHttpRequest(request)
+	if err = httpVcr.applyRequestMutators(&serializableRequest); err != nil {
+		return &http.Response{}, err
+	}
+
+	replayer := httpVcr.currentReplayer()
+	if replayer == nil {
+		return &http.Response{}, fmt.Errorf("no cassette is loaded for replay")
+	}
+
+	responseWrapper, err := replayer.Write(serializableRequest)
 	if err != nil {
 		return &http.Response{}, err
 	}
 
 	response := (*responseWrapper).(*http.Response)
 
+	serializableResponse := NewSerializableHttpResponse(response)
+	if err = httpVcr.applyResponseMutators(&serializableRequest, &serializableResponse); err != nil {
+		return &http.Response{}, err
+	}
+	response = serializableResponse.ToHttpResponse()
+
 	return response, err
 }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// caValidityDuration mirrors the --duration flag the old generate_cert.go shell-out used, so
+// existing cassettes recorded against a long-lived root keep working.
+const caValidityDuration = 100000 * time.Hour
+
+// CertificateAuthority mints TLS leaf certificates on the fly so HttpVcr can MITM HTTPS traffic to
+// any upstream (not just a single host baked into cert.pem/key.pem). Leaves are cached by SNI
+// hostname so repeated CONNECTs to the same host reuse one certificate.
+type CertificateAuthority struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// NewCertificateAuthority loads a root CA keypair from certPath/keyPath if both files already
+// exist, or generates and persists a fresh long-lived one otherwise.
+func NewCertificateAuthority(certPath, keyPath string) (*CertificateAuthority, error) {
+	cert, key, err := loadOrGenerateCA(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateAuthority{
+		cert:   cert,
+		key:    key,
+		leaves: make(map[string]*tls.Certificate),
+	}, nil
+}
+
+func loadOrGenerateCA(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if certErr == nil && keyErr == nil {
+		return loadCA(certPath, keyPath)
+	}
+
+	return generateCA(certPath, keyPath)
+}
+
+func loadCA(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CA cert: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CA key: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM block found in %v", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing CA cert: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM block found in %v", keyPath)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing CA key: %w", err)
+	}
+
+	return cert, key, nil
+}
+
+func generateCA(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating CA serial number: %w", err)
+	}
+
+	notBefore := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"stripe-cli HttpVcr local CA"},
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(caValidityDuration),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating CA cert: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing freshly generated CA cert: %w", err)
+	}
+
+	if err := writePEMFile(certPath, "CERTIFICATE", derBytes); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEMFile(keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)); err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func writePEMFile(path, blockType string, der []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating %v: %w", path, err)
+	}
+	defer file.Close()
+
+	return pem.Encode(file, &pem.Block{Type: blockType, Bytes: der})
+}
+
+// certPEM returns the CA's certificate PEM bytes, for serving at /vcr/ca.pem.
+func (ca *CertificateAuthority) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// leafFor mints (or returns a cached) leaf certificate for host, signed by the CA.
+func (ca *CertificateAuthority) leafFor(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if leaf, ok := ca.leaves[host]; ok {
+		return leaf, nil
+	}
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generating leaf key for %v: %w", host, err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating leaf serial for %v: %w", host, err)
+	}
+
+	notBefore := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(caValidityDuration),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &leafKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("signing leaf cert for %v: %w", host, err)
+	}
+
+	leaf := &tls.Certificate{
+		Certificate: [][]byte{derBytes, ca.cert.Raw},
+		PrivateKey:  leafKey,
+	}
+	ca.leaves[host] = leaf
+
+	return leaf, nil
+}
+
+// handleConnect hijacks a CONNECT request's TCP connection, completes a TLS handshake using a
+// leaf certificate minted for the requested SNI, and dispatches every decrypted request on that
+// connection through dispatch (HttpVcr.handler) until the client disconnects.
+func (ca *CertificateAuthority) handleConnect(w http.ResponseWriter, r *http.Request, dispatch http.HandlerFunc) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection does not support hijacking", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("hijacking connection: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	host := r.URL.Hostname()
+	if host == "" {
+		host, _, _ = net.SplitHostPort(r.Host)
+	}
+	if host == "" {
+		host = r.Host
+	}
+
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			sni := hello.ServerName
+			if sni == "" {
+				sni = host
+			}
+			return ca.leafFor(sni)
+		},
+	})
+	defer tlsConn.Close()
+
+	if err := tlsConn.Handshake(); err != nil {
+		fmt.Println("\nMITM TLS handshake failed: ", err)
+		return
+	}
+
+	reader := bufio.NewReader(tlsConn)
+	for {
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			return // client closed the connection, or it was the last request
+		}
+
+		request.URL.Scheme = "https"
+		request.URL.Host = host
+		request.RequestURI = ""
+
+		responseWriter := newHijackedResponseWriter(tlsConn)
+		dispatch(responseWriter, request)
+		if err := responseWriter.flush(); err != nil {
+			return
+		}
+	}
+}
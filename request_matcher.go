@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// RequestMatcher decides whether a recorded track's request matches an incoming (live or replay)
+// request. candidate is the request being looked up; track is a request already on the cassette.
+type RequestMatcher interface {
+	Match(candidate HttpRequestSerializable, track HttpRequestSerializable) bool
+	fmt.Stringer
+}
+
+// requestMatcherFunc adapts a plain function to RequestMatcher, similar to http.HandlerFunc.
+type requestMatcherFunc struct {
+	name string
+	fn   func(candidate HttpRequestSerializable, track HttpRequestSerializable) bool
+}
+
+func (m requestMatcherFunc) Match(candidate HttpRequestSerializable, track HttpRequestSerializable) bool {
+	return m.fn(candidate, track)
+}
+
+func (m requestMatcherFunc) String() string {
+	return m.name
+}
+
+// MatchMethodAndPath accepts a track whose method and URL path equal the candidate's.
+var MatchMethodAndPath RequestMatcher = requestMatcherFunc{
+	name: "method+path",
+	fn: func(candidate, track HttpRequestSerializable) bool {
+		return candidate.Method == track.Method && candidate.URL.Path == track.URL.Path
+	},
+}
+
+// MatchMethodPathQuery additionally requires the raw query string to match.
+var MatchMethodPathQuery RequestMatcher = requestMatcherFunc{
+	name: "method+path+query",
+	fn: func(candidate, track HttpRequestSerializable) bool {
+		return candidate.Method == track.Method &&
+			candidate.URL.Path == track.URL.Path &&
+			candidate.URL.RawQuery == track.URL.RawQuery
+	},
+}
+
+// MatchMethodPathJSONBody additionally requires the bodies to be JSON-equivalent, i.e. equal once
+// decoded, so that key ordering and whitespace differences are ignored.
+var MatchMethodPathJSONBody RequestMatcher = requestMatcherFunc{
+	name: "method+path+body(json)",
+	fn: func(candidate, track HttpRequestSerializable) bool {
+		if candidate.Method != track.Method || candidate.URL.Path != track.URL.Path {
+			return false
+		}
+		return jsonEquivalent(candidate.Body, track.Body)
+	},
+}
+
+// MatchHeaderSubset accepts a track whose header values, for each of the given names, equal the
+// candidate's. Headers not in the list are ignored.
+func MatchHeaderSubset(headerNames ...string) RequestMatcher {
+	return requestMatcherFunc{
+		name: fmt.Sprintf("headers%v", headerNames),
+		fn: func(candidate, track HttpRequestSerializable) bool {
+			for _, name := range headerNames {
+				if candidate.Header.Get(name) != track.Header.Get(name) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// AndMatchers accepts only when every matcher accepts.
+func AndMatchers(matchers ...RequestMatcher) RequestMatcher {
+	return requestMatcherFunc{
+		name: "and",
+		fn: func(candidate, track HttpRequestSerializable) bool {
+			for _, m := range matchers {
+				if !m.Match(candidate, track) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// OrMatchers accepts when any matcher accepts.
+func OrMatchers(matchers ...RequestMatcher) RequestMatcher {
+	return requestMatcherFunc{
+		name: "or",
+		fn: func(candidate, track HttpRequestSerializable) bool {
+			for _, m := range matchers {
+				if m.Match(candidate, track) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// NearMissError is returned when replay exhausts the cassette without finding a match. It lists
+// the candidate request alongside every track that was considered, so a failing test can show why
+// each near-miss was rejected instead of just "no match".
+type NearMissError struct {
+	Candidate HttpRequestSerializable
+	NearMisses []HttpRequestSerializable
+}
+
+func (e *NearMissError) Error() string {
+	return fmt.Sprintf("no cassette track matches %v %v (considered %d near-miss track(s))",
+		e.Candidate.Method, e.Candidate.URL.Path, len(e.NearMisses))
+}
+
+// comparator bridges the configured RequestMatcher (and strictOrder toggle) to the
+// accept/shortCircuit signature VcrReplayer expects. VcrReplayer calls this once per unplayed
+// track, in cassette order. With strictOrder on, shortCircuitNow is always true: the very first
+// track considered decides the outcome, enforcing that tracks are consumed in recording order.
+// With strictOrder off, shortCircuitNow is always false, so VcrReplayer keeps scanning every
+// remaining track and picks the best-scoring accepted one rather than the first.
+func (httpVcr *HttpVcr) comparator() func(req1 interface{}, req2 interface{}) (accept bool, shortCircuitNow bool) {
+	matcher := httpVcr.requestMatcher
+	if matcher == nil {
+		matcher = MatchMethodAndPath
+	}
+
+	return func(req1 interface{}, req2 interface{}) (accept bool, shortCircuitNow bool) {
+		candidate, ok1 := req1.(HttpRequestSerializable)
+		track, ok2 := req2.(HttpRequestSerializable)
+		if !ok1 || !ok2 {
+			return false, httpVcr.strictOrder
+		}
+
+		return matcher.Match(candidate, track), httpVcr.strictOrder
+	}
+}
+
+// matchSpecificity scores how closely track resembles candidate, independent of which
+// RequestMatcher accepted it, so VcrReplayer can rank several accepted tracks and prefer the best
+// one instead of the first found in cassette order. Higher is closer. Method and path are not
+// scored (the built-in matchers other than MatchHeaderSubset already require them to agree); the
+// score comes from the query string, body, and headers.
+func matchSpecificity(candidate, track HttpRequestSerializable) int {
+	score := 0
+	if candidate.URL.RawQuery == track.URL.RawQuery {
+		score++
+	}
+	if jsonEquivalent(candidate.Body, track.Body) {
+		score++
+	}
+	for name, values := range candidate.Header {
+		if track.Header.Get(name) == "" {
+			continue
+		}
+		for _, v := range values {
+			if track.Header.Get(name) == v {
+				score++
+				break
+			}
+		}
+	}
+	return score
+}
+
+// jsonEquivalent reports whether a and b decode to equal values, ignoring key order and
+// formatting. Non-JSON bodies fall back to a byte-for-byte comparison.
+func jsonEquivalent(a, b []byte) bool {
+	var decodedA, decodedB interface{}
+	if json.Unmarshal(a, &decodedA) != nil || json.Unmarshal(b, &decodedB) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(decodedA, decodedB)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// HttpRequestSerializable is a snapshot of an *http.Request that can be persisted to a cassette
+// and compared against later, independent of the original request's (now-closed) body reader.
+type HttpRequestSerializable struct {
+	Method string
+	URL    url.URL
+	Header http.Header
+	Body   []byte
+}
+
+// NewSerializableHttpRequest snapshots r, re-filling r.Body so it can still be read downstream.
+func NewSerializableHttpRequest(r *http.Request) HttpRequestSerializable {
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	}
+
+	serializableURL := *r.URL
+
+	return HttpRequestSerializable{
+		Method: r.Method,
+		URL:    serializableURL,
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+}
+
+// HttpResponseSerializable is a snapshot of an *http.Response that can be persisted to a cassette
+// and turned back into an *http.Response at replay time.
+type HttpResponseSerializable struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// NewSerializableHttpResponse snapshots resp, re-filling resp.Body so it can still be read downstream.
+func NewSerializableHttpResponse(resp *http.Response) HttpResponseSerializable {
+	var body []byte
+	if resp.Body != nil {
+		body, _ = ioutil.ReadAll(resp.Body)
+		resp.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	}
+
+	return HttpResponseSerializable{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+	}
+}
+
+// ToHttpResponse turns a replayed track's response back into an *http.Response the rest of
+// HttpVcr can treat exactly like a live response from the remote.
+func (s HttpResponseSerializable) ToHttpResponse() *http.Response {
+	return &http.Response{
+		StatusCode:    s.StatusCode,
+		Status:        http.StatusText(s.StatusCode),
+		Header:        s.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(s.Body)),
+		ContentLength: int64(len(s.Body)),
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+// check panics on err, for the handful of startup-time calls in main where there is no
+// reasonable way to continue (e.g. the cassette or CA files can't be read).
+func check(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// VcrMode selects how HttpVcr handles an incoming request.
+type VcrMode string
+
+const (
+	// ModeRecord always forwards to the remote and records the exchange.
+	ModeRecord VcrMode = "record"
+	// ModeReplay always serves from the cassette; no unmatched request ever reaches the remote.
+	ModeReplay VcrMode = "replay"
+	// ModePassthrough always forwards to the remote, recording nothing.
+	ModePassthrough VcrMode = "passthrough"
+	// ModeAuto is decided once, when the cassette is opened: replay if it already has tracks,
+	// record if it's empty or doesn't exist yet.
+	ModeAuto VcrMode = "auto"
+	// ModeHybrid serves from the cassette when a track matches, and otherwise falls through to
+	// the remote and records the result, so a cassette fills in its own gaps over time.
+	ModeHybrid VcrMode = "hybrid"
+)
+
+func (mode VcrMode) valid() bool {
+	switch mode {
+	case ModeRecord, ModeReplay, ModePassthrough, ModeAuto, ModeHybrid:
+		return true
+	default:
+		return false
+	}
+}
+
+// currentMode reads the active mode under lock.
+func (httpVcr *HttpVcr) currentMode() VcrMode {
+	httpVcr.mu.Lock()
+	defer httpVcr.mu.Unlock()
+	return httpVcr.mode
+}
+
+// currentRecorder reads the active recorder under lock (nil if the current mode never records).
+func (httpVcr *HttpVcr) currentRecorder() *VcrRecorder {
+	httpVcr.mu.Lock()
+	defer httpVcr.mu.Unlock()
+	return httpVcr.recorder
+}
+
+// currentReplayer reads the active replayer under lock (nil if the current mode never replays).
+func (httpVcr *HttpVcr) currentReplayer() *VcrReplayer {
+	httpVcr.mu.Lock()
+	defer httpVcr.mu.Unlock()
+	return httpVcr.replayer
+}
+
+// record persists one exchange via the current recorder, and mirrors it into the current
+// replayer's in-memory tracks too (if one is loaded) so ModeHybrid can replay a request it just
+// recorded without needing a restart.
+func (httpVcr *HttpVcr) record(req HttpRequestSerializable, resp HttpResponseSerializable) error {
+	httpVcr.mu.Lock()
+	recorder := httpVcr.recorder
+	replayer := httpVcr.replayer
+	httpVcr.mu.Unlock()
+
+	if recorder == nil {
+		return fmt.Errorf("no cassette is loaded for recording")
+	}
+
+	if err := recorder.Write(req, resp); err != nil {
+		return err
+	}
+
+	if replayer != nil {
+		replayer.appendTrack(Track{Request: req, Response: resp})
+	}
+	return nil
+}
+
+// openCassette closes out whatever cassette is currently loaded and opens name under mode,
+// building whichever of recorder/replayer that mode needs.
+func (httpVcr *HttpVcr) openCassette(name string, mode VcrMode) error {
+	httpVcr.mu.Lock()
+	defer httpVcr.mu.Unlock()
+
+	if httpVcr.recorder != nil {
+		if err := httpVcr.recorder.Close(); err != nil {
+			return err
+		}
+	}
+
+	return httpVcr.openCassetteLocked(name, mode)
+}
+
+// openCassetteLocked does the actual work of openCassette; callers must already hold httpVcr.mu.
+func (httpVcr *HttpVcr) openCassetteLocked(name string, mode VcrMode) error {
+	if !mode.valid() {
+		return fmt.Errorf("unknown VCR mode %q", mode)
+	}
+
+	if mode == ModeAuto {
+		mode = autoResolveMode(httpVcr.newCassetteFor(name))
+	}
+
+	var recorder *VcrRecorder
+	var replayer *VcrReplayer
+
+	if mode == ModeRecord || mode == ModeHybrid {
+		// ModeRecord always starts from an empty cassette; ModeHybrid keeps what's already there
+		// so it can replay from it before falling through to the remote.
+		if mode == ModeRecord {
+			if err := removeIfExists(name); err != nil {
+				return err
+			}
+		}
+
+		cassette, err := httpVcr.cassetteFor(name)
+		if err != nil {
+			return err
+		}
+		recorder = NewRecorderWithCassette(cassette)
+	}
+
+	if mode == ModeReplay {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return fmt.Errorf("cassette %q does not exist, cannot replay from it", name)
+		}
+	}
+
+	if mode == ModeReplay || mode == ModeHybrid {
+		cassette, err := httpVcr.cassetteFor(name)
+		if err != nil {
+			return err
+		}
+		r, err := NewReplayerWithCassette(cassette, httpVcr.comparator(), httpVcr.matchMany)
+		if err != nil {
+			return err
+		}
+		replayer = r
+	}
+
+	httpVcr.mode = mode
+	httpVcr.cassetteName = name
+	httpVcr.recorder = recorder
+	httpVcr.replayer = replayer
+	return nil
+}
+
+// cassetteFor opens the backend for name, honoring an explicit WithCassette override.
+func (httpVcr *HttpVcr) cassetteFor(name string) (Cassette, error) {
+	if httpVcr.cassette != nil {
+		return httpVcr.cassette, nil
+	}
+	return NewCassette(name)
+}
+
+// newCassetteFor is like cassetteFor but never returns an error, for the auto-mode probe below
+// where a missing/corrupt cassette just means "treat it as empty, go to record mode".
+func (httpVcr *HttpVcr) newCassetteFor(name string) Cassette {
+	cassette, err := httpVcr.cassetteFor(name)
+	if err != nil {
+		return nil
+	}
+	return cassette
+}
+
+func autoResolveMode(cassette Cassette) VcrMode {
+	if cassette == nil {
+		return ModeRecord
+	}
+	tracks, err := cassette.Load()
+	if err != nil || len(tracks) == 0 {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// VcrStatus is the JSON shape returned by GET /vcr/status.
+type VcrStatus struct {
+	Mode            VcrMode `json:"mode"`
+	Cassette        string  `json:"cassette"`
+	TrackCursor     int     `json:"trackCursor"`
+	RemainingTracks int     `json:"remainingTracks"`
+}
+
+// status reports the current mode, cassette, and replay progress.
+func (httpVcr *HttpVcr) status() VcrStatus {
+	httpVcr.mu.Lock()
+	mode, name, replayer := httpVcr.mode, httpVcr.cassetteName, httpVcr.replayer
+	httpVcr.mu.Unlock()
+
+	status := VcrStatus{Mode: mode, Cassette: name}
+	if replayer != nil {
+		status.TrackCursor, status.RemainingTracks = replayer.cursor()
+	}
+	return status
+}
+
+// rewind resets the current replayer's cursor so every track can be served again from the start.
+func (httpVcr *HttpVcr) rewind() error {
+	replayer := httpVcr.currentReplayer()
+	if replayer == nil {
+		return fmt.Errorf("no cassette is loaded for replay")
+	}
+	replayer.rewind()
+	return nil
+}
+
+// dropTrack removes track n (0-indexed) from the current replayer.
+func (httpVcr *HttpVcr) dropTrack(n int) error {
+	replayer := httpVcr.currentReplayer()
+	if replayer == nil {
+		return fmt.Errorf("no cassette is loaded for replay")
+	}
+	return replayer.dropTrack(n)
+}
+
+// registerControlAPI mounts the /vcr/* control-plane endpoints onto mux.
+func (httpVcr *HttpVcr) registerControlAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/vcr/cassette", httpVcr.handleSwitchCassette)
+	mux.HandleFunc("/vcr/mode", httpVcr.handleSetMode)
+	mux.HandleFunc("/vcr/status", httpVcr.handleStatus)
+	mux.HandleFunc("/vcr/rewind", httpVcr.handleRewind)
+	mux.HandleFunc("/vcr/tracks/", httpVcr.handleDropTrack)
+}
+
+func (httpVcr *HttpVcr) handleSwitchCassette(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Name string  `json:"name"`
+		Mode VcrMode `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" {
+		http.Error(w, "\"name\" is required", http.StatusBadRequest)
+		return
+	}
+	if body.Mode == "" {
+		body.Mode = ModeReplay
+	}
+
+	if err := httpVcr.openCassette(body.Name, body.Mode); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, httpVcr.status())
+}
+
+func (httpVcr *HttpVcr) handleSetMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Mode VcrMode `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	httpVcr.mu.Lock()
+	name := httpVcr.cassetteName
+	httpVcr.mu.Unlock()
+
+	if err := httpVcr.openCassette(name, body.Mode); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, httpVcr.status())
+}
+
+func (httpVcr *HttpVcr) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, httpVcr.status())
+}
+
+func (httpVcr *HttpVcr) handleRewind(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := httpVcr.rewind(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, httpVcr.status())
+}
+
+func (httpVcr *HttpVcr) handleDropTrack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	indexString := strings.TrimPrefix(r.URL.Path, "/vcr/tracks/")
+	index, err := strconv.Atoi(indexString)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid track index %q", indexString), http.StatusBadRequest)
+		return
+	}
+
+	if err := httpVcr.dropTrack(index); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, httpVcr.status())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("\nfailed to write JSON response: ", err)
+	}
+}
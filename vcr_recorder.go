@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// VcrRecorder appends every request/response pair it is given to a Cassette, in order.
+type VcrRecorder struct {
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder opens (or creates) the cassette at filepath, picking a backend by file extension.
+func NewRecorder(filepath string) (*VcrRecorder, error) {
+	cassette, err := NewCassette(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecorderWithCassette(cassette), nil
+}
+
+// NewRecorderWithCassette records directly onto cassette, bypassing extension-based backend
+// selection. Used when an explicit WithCassette option overrides the default.
+func NewRecorderWithCassette(cassette Cassette) *VcrRecorder {
+	return &VcrRecorder{cassette: cassette}
+}
+
+// Write persists one track to the cassette. Serialized with a mutex because the YAML/JSON/HAR
+// backends read-modify-write the whole file on every Append, so concurrent callers would
+// otherwise race and silently drop each other's tracks.
+func (recorder *VcrRecorder) Write(req HttpRequestSerializable, resp HttpResponseSerializable) error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	return recorder.cassette.Append(Track{
+		Request:    req,
+		Response:   resp,
+		RecordedAt: time.Now(),
+	})
+}
+
+// Close flushes and releases the underlying cassette.
+func (recorder *VcrRecorder) Close() error {
+	return recorder.cassette.Close()
+}
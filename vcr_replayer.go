@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// VcrReplayer serves recorded responses back in response to incoming requests, using a
+// comparator to decide which unplayed track (if any) matches.
+type VcrReplayer struct {
+	mu        sync.Mutex
+	tracks    []Track
+	played    []bool
+	matchMany bool
+
+	// comparator mirrors the shape HttpVcr.comparator() builds from a RequestMatcher:
+	// accept reports whether req2 (a track's request) matches req1 (the incoming request);
+	// shortCircuitNow reports whether the search should stop without considering later tracks.
+	comparator func(req1 interface{}, req2 interface{}) (accept bool, shortCircuitNow bool)
+}
+
+// NewReplayer loads every track off the cassette at filepath up front; replay only ever reads.
+// The blank HttpRequestSerializable{}/HttpResponseSerializable{} parameters exist so callers can
+// pin the types being replayed without a generic type parameter.
+func NewReplayer(filepath string, _ HttpRequestSerializable, _ HttpResponseSerializable, comparator func(req1 interface{}, req2 interface{}) (bool, bool)) (*VcrReplayer, error) {
+	cassette, err := NewCassette(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplayerWithCassette(cassette, comparator, false)
+}
+
+// NewReplayerWithCassette replays directly from cassette, bypassing extension-based backend
+// selection. Used when an explicit WithCassette option overrides the default. matchMany switches
+// every track from match-once (the default: a track is consumed once it answers a request) to
+// match-many (a track stays available for later requests too), per WithMatchMany.
+//
+// It deliberately does not Close the cassette after loading: in ModeHybrid the same Cassette
+// instance is also handed to the recorder (see openCassetteLocked), which keeps writing to it
+// long after replay has loaded its snapshot, so closing here would pull the rug out from under
+// that recorder for a WithCassette backend that takes Close seriously.
+func NewReplayerWithCassette(cassette Cassette, comparator func(req1 interface{}, req2 interface{}) (bool, bool), matchMany bool) (*VcrReplayer, error) {
+	tracks, err := cassette.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &VcrReplayer{
+		tracks:     tracks,
+		played:     make([]bool, len(tracks)),
+		matchMany:  matchMany,
+		comparator: comparator,
+	}, nil
+}
+
+// Write (named to mirror VcrRecorder.Write, despite being a read) searches the unplayed tracks
+// for the best one the comparator accepts and returns its response, wrapped as an interface{} so
+// the caller can type-assert it back to *http.Response. "Best" means the accepted track whose
+// request is the closest overall match to req (see matchSpecificity), not just the first
+// candidate encountered in cassette order. If the comparator short-circuits before accepting
+// anything, or no unplayed track matches, it returns a *NearMissError listing every unplayed
+// track that was considered. Unless the replayer was built with matchMany, the winning track is
+// marked played so it can't answer a second request.
+func (replayer *VcrReplayer) Write(req HttpRequestSerializable) (*interface{}, error) {
+	replayer.mu.Lock()
+	defer replayer.mu.Unlock()
+
+	var nearMisses []HttpRequestSerializable
+	bestIndex := -1
+	bestScore := -1
+
+	for i, track := range replayer.tracks {
+		if replayer.played[i] {
+			continue
+		}
+
+		accept, shortCircuitNow := replayer.comparator(req, track.Request)
+		if accept {
+			if score := matchSpecificity(req, track.Request); bestIndex == -1 || score > bestScore {
+				bestIndex, bestScore = i, score
+			}
+			if shortCircuitNow {
+				break
+			}
+			continue
+		}
+
+		nearMisses = append(nearMisses, track.Request)
+		if shortCircuitNow {
+			break
+		}
+	}
+
+	if bestIndex == -1 {
+		return nil, &NearMissError{Candidate: req, NearMisses: nearMisses}
+	}
+
+	if !replayer.matchMany {
+		replayer.played[bestIndex] = true
+	}
+
+	var response interface{} = replayer.tracks[bestIndex].Response.ToHttpResponse()
+	return &response, nil
+}
+
+// cursor reports how many tracks have been played and how many remain unplayed.
+func (replayer *VcrReplayer) cursor() (played int, remaining int) {
+	replayer.mu.Lock()
+	defer replayer.mu.Unlock()
+
+	for _, p := range replayer.played {
+		if p {
+			played++
+		} else {
+			remaining++
+		}
+	}
+	return played, remaining
+}
+
+// rewind marks every track as unplayed again, so replay can start over from the beginning.
+func (replayer *VcrReplayer) rewind() {
+	replayer.mu.Lock()
+	defer replayer.mu.Unlock()
+
+	for i := range replayer.played {
+		replayer.played[i] = false
+	}
+}
+
+// appendTrack adds a newly recorded track to the in-memory replay set (unplayed), so a track
+// recorded moments ago in ModeHybrid can be replayed without reopening the cassette.
+func (replayer *VcrReplayer) appendTrack(track Track) {
+	replayer.mu.Lock()
+	defer replayer.mu.Unlock()
+
+	replayer.tracks = append(replayer.tracks, track)
+	replayer.played = append(replayer.played, false)
+}
+
+// dropTrack removes track n (0-indexed, in cassette order) so it can never be matched again.
+func (replayer *VcrReplayer) dropTrack(n int) error {
+	replayer.mu.Lock()
+	defer replayer.mu.Unlock()
+
+	if n < 0 || n >= len(replayer.tracks) {
+		return fmt.Errorf("track index %d out of range (cassette has %d tracks)", n, len(replayer.tracks))
+	}
+
+	replayer.tracks = append(replayer.tracks[:n], replayer.tracks[n+1:]...)
+	replayer.played = append(replayer.played[:n], replayer.played[n+1:]...)
+	return nil
+}